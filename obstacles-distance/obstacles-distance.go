@@ -1,30 +1,41 @@
 // Package obstaclesdistance uses an underlying camera to fulfill vision service methods, specifically
 // GetObjectPointClouds, which performs several queries of NextPointCloud and returns a median point.
 // The RDK version of this service is buggy and should be further investigated at some point. This implements
-// the same functionality, and demonstrates the same buggy and laggy behavior as the RDK version.
+// the same functionality, and demonstrates the same buggy and laggy behavior as the RDK version. Queries run
+// concurrently (bounded by MaxConcurrentQueries) or, with Streaming enabled, are served from a
+// continuously-refreshed rolling window, to reduce that lag. With a movement sensor configured
+// and Streaming disabled, queries run one at a time instead.
 package obstaclesdistance
 
 import (
-    "context"
-    "image"
-    "math"
-    "sort"
-
-    "github.com/golang/geo/r3"
-    "github.com/pkg/errors"
-    "go.opencensus.io/trace"
-
-    "go.viam.com/rdk/components/camera"
-    "go.viam.com/rdk/logging"
-    "go.viam.com/rdk/pointcloud"
-    "go.viam.com/rdk/resource"
-    vision "go.viam.com/rdk/services/vision"
-    "go.viam.com/rdk/spatialmath"
-    "go.viam.com/rdk/utils"
-    vis "go.viam.com/rdk/vision"
-    "go.viam.com/rdk/vision/classification"
-    "go.viam.com/rdk/vision/objectdetection"
-    "go.viam.com/rdk/vision/viscapture"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"image"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/geo/r3"
+	"github.com/pkg/errors"
+	"go.opencensus.io/trace"
+	"golang.org/x/sync/errgroup"
+
+	"go.viam.com/rdk/components/camera"
+	"go.viam.com/rdk/components/movementsensor"
+	"go.viam.com/rdk/logging"
+	"go.viam.com/rdk/pointcloud"
+	"go.viam.com/rdk/resource"
+	"go.viam.com/rdk/rimage"
+	vision "go.viam.com/rdk/services/vision"
+	"go.viam.com/rdk/spatialmath"
+	"go.viam.com/rdk/transform"
+	"go.viam.com/rdk/utils"
+	vis "go.viam.com/rdk/vision"
+	"go.viam.com/rdk/vision/classification"
+	"go.viam.com/rdk/vision/objectdetection"
+	"go.viam.com/rdk/vision/viscapture"
 	"go.viam.com/utils/rpc"
 )
 
@@ -34,21 +45,161 @@ var errUnimplemented = errors.New("obstacles distance service does not implement
 // DefaultNumQueries is the default number of times the camera should be queried before averaging.
 const DefaultNumQueries = 10
 
+// DefaultClusteringRadiusMm is the default neighbor radius used by radius clustering mode.
+const DefaultClusteringRadiusMm = 50.0
+
+// DefaultMinClusterSize is the default minimum number of points a cluster needs to be reported.
+const DefaultMinClusterSize = 3
+
+// Supported values for DistanceDetectorConfig.Mode.
+const (
+	ModeNearest          = "nearest"
+	ModeRadiusClustering = "radius_clustering"
+)
+
 // DistanceDetectorConfig specifies the parameters for the camera to be used
 // for the obstacle distance detection service.
 type DistanceDetectorConfig struct {
 	resource.TriviallyValidateConfig
 	NumQueries    int    `json:"num_queries"`
 	DefaultCamera string `json:"camera_name"`
+
+	// Mode selects how obstacles are extracted from the accumulated point clouds.
+	// One of "nearest" (default, single closest-point median) or "radius_clustering"
+	// (one obstacle per spatial cluster).
+	Mode string `json:"mode"`
+
+	// ClusteringRadiusMm is the neighbor radius, in millimeters, used to merge points
+	// into the same cluster when Mode is "radius_clustering".
+	ClusteringRadiusMm float64 `json:"clustering_radius_mm"`
+	// MinClusterSize is the minimum number of points a cluster must contain to be
+	// reported as an obstacle when Mode is "radius_clustering".
+	MinClusterSize int `json:"min_cluster_size"`
+	// MaxDistanceMm, if set, discards points farther than this distance (in millimeters)
+	// from the camera origin before the closest/median/cluster computation runs.
+	MaxDistanceMm float64 `json:"max_distance_mm"`
+	// MinDistanceMm, if set, discards points nearer than this distance (in millimeters)
+	// from the camera origin before the closest/median/cluster computation runs.
+	MinDistanceMm float64 `json:"min_distance_mm"`
+
+	// ExcludeBoxes lists axis-aligned boxes, in the camera frame, whose contents should be
+	// dropped before the closest/median/cluster computation runs. Useful for masking out
+	// parts of the robot chassis that are visible to the camera.
+	ExcludeBoxes []ExcludeBox `json:"exclude_boxes"`
+	// ROI, if set, restricts points to an axis-aligned window of the camera's image plane,
+	// in pixels, projecting each point through the camera's intrinsics before testing it
+	// against the window. Requires a camera that provides intrinsics.
+	ROI *ROI `json:"roi"`
+
+	// RequirePointCloud controls whether the camera must natively support NextPointCloud.
+	// Defaults to true. When false, a camera that only supports images plus intrinsics
+	// falls back to building point clouds from its depth image via its projector.
+	RequirePointCloud *bool `json:"require_point_cloud"`
+
+	// MaxConcurrentQueries bounds how many NextPointCloud calls run at once. Defaults to
+	// NumQueries (fully concurrent); must not exceed NumQueries.
+	MaxConcurrentQueries int `json:"max_concurrent_queries"`
+	// QueryTimeoutMs, if set, bounds each individual NextPointCloud call.
+	QueryTimeoutMs int `json:"query_timeout_ms"`
+	// Streaming, if true, continuously acquires point clouds in the background into a
+	// rolling window of size NumQueries instead of blocking on fresh acquisitions for
+	// every GetObjectPointClouds call.
+	Streaming bool `json:"streaming"`
+
+	// MovementSensor optionally names a movement sensor used to compensate the accumulated
+	// clouds for robot motion between queries before computing the closest/median point or
+	// clustering them, so the multi-query result reflects a single moment rather than
+	// mixing points sampled from different world positions.
+	MovementSensor string `json:"movement_sensor"`
+}
+
+// ExcludeBox describes a 3D box, in millimeters in the camera frame, to exclude from
+// consideration. Points within HalfSize of Center on every axis are dropped.
+type ExcludeBox struct {
+	CenterXMm   float64 `json:"center_x_mm"`
+	CenterYMm   float64 `json:"center_y_mm"`
+	CenterZMm   float64 `json:"center_z_mm"`
+	HalfSizeXMm float64 `json:"half_size_x_mm"`
+	HalfSizeYMm float64 `json:"half_size_y_mm"`
+	HalfSizeZMm float64 `json:"half_size_z_mm"`
+}
+
+// ROI describes an axis-aligned window, in pixels of the camera's image plane, outside of
+// which points are dropped once projected through the camera's intrinsics. Combine with
+// MinDistanceMm/MaxDistanceMm or an ExcludeBox to also gate on depth.
+type ROI struct {
+	MinXPx float64 `json:"min_x_px"`
+	MinYPx float64 `json:"min_y_px"`
+	MaxXPx float64 `json:"max_x_px"`
+	MaxYPx float64 `json:"max_y_px"`
 }
 
 type obstacleDistanceService struct {
-    resource.AlwaysRebuild
-    name         resource.Name
-    logger       logging.Logger
-    segmenter    func(context.Context, camera.Camera) ([]*vis.Object, error)
-    defaultCamera camera.Camera
-    deps         resource.Dependencies
+	resource.AlwaysRebuild
+	name      resource.Name
+	logger    logging.Logger
+	segmenter func(context.Context, []pointcloud.PointCloud, *DistanceDetectorConfig, *transform.PinholeCameraIntrinsics) ([]*vis.Object, pointcloud.PointCloud, int, error)
+	deps      resource.Dependencies
+
+	mu             sync.RWMutex
+	conf           *DistanceDetectorConfig
+	defaultCamera  camera.Camera
+	movementSensor movementsensor.MovementSensor
+
+	stats        queryStats
+	lastRawCloud pointcloud.PointCloud
+
+	streamMu     sync.Mutex
+	streamCancel context.CancelFunc
+	streamDone   chan struct{}
+	streamBuffer *ringBuffer
+
+	motionWarnOnce sync.Once
+}
+
+// statsWindowSize bounds how many recent query latencies get_stats reports.
+const statsWindowSize = 20
+
+// queryStats tracks rolling acquisition diagnostics surfaced via the get_stats DoCommand.
+type queryStats struct {
+	mu             sync.Mutex
+	latencies      []time.Duration
+	totalQueries   int
+	emptyQueries   int
+	filteredPoints int64
+}
+
+func (q *queryStats) record(latency time.Duration, empty bool, filteredOut int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.latencies = append(q.latencies, latency)
+	if len(q.latencies) > statsWindowSize {
+		q.latencies = q.latencies[len(q.latencies)-statsWindowSize:]
+	}
+	q.totalQueries++
+	if empty {
+		q.emptyQueries++
+	}
+	q.filteredPoints += int64(filteredOut)
+}
+
+func (q *queryStats) snapshot() map[string]interface{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	latenciesMs := make([]float64, len(q.latencies))
+	for i, l := range q.latencies {
+		latenciesMs[i] = float64(l.Microseconds()) / 1000.0
+	}
+	emptyRate := 0.0
+	if q.totalQueries > 0 {
+		emptyRate = float64(q.emptyQueries) / float64(q.totalQueries)
+	}
+	return map[string]interface{}{
+		"recent_latencies_ms":  latenciesMs,
+		"total_queries":        q.totalQueries,
+		"empty_cloud_rate":     emptyRate,
+		"filtered_point_count": q.filteredPoints,
+	}
 }
 
 func init() {
@@ -76,127 +227,747 @@ func (config *DistanceDetectorConfig) Validate(path string) ([]string, []string,
 	if config.NumQueries < 1 || config.NumQueries > 20 {
 		return nil, nil, errors.New("invalid number of queries, pick a number between 1 and 20")
 	}
-    if config.DefaultCamera != "" {
-        reqDeps = append(reqDeps, config.DefaultCamera)
-    }
+	if config.DefaultCamera != "" {
+		reqDeps = append(reqDeps, config.DefaultCamera)
+	}
+	if config.MovementSensor != "" {
+		optDeps = append(optDeps, config.MovementSensor)
+	}
+
+	switch config.Mode {
+	case "":
+		config.Mode = ModeNearest
+	case ModeNearest, ModeRadiusClustering:
+	default:
+		return nil, nil, errors.Errorf("invalid mode %q, must be %q or %q", config.Mode, ModeNearest, ModeRadiusClustering)
+	}
+
+	if config.Mode == ModeRadiusClustering {
+		if config.ClusteringRadiusMm == 0 {
+			config.ClusteringRadiusMm = DefaultClusteringRadiusMm
+		}
+		if config.ClusteringRadiusMm <= 0 {
+			return nil, nil, errors.New("clustering_radius_mm must be positive")
+		}
+		if config.MinClusterSize == 0 {
+			config.MinClusterSize = DefaultMinClusterSize
+		}
+		if config.MinClusterSize < 1 {
+			return nil, nil, errors.New("min_cluster_size must be at least 1")
+		}
+	}
+	if config.MinDistanceMm < 0 || config.MaxDistanceMm < 0 {
+		return nil, nil, errors.New("min_distance_mm and max_distance_mm must not be negative")
+	}
+	if config.MaxDistanceMm > 0 && config.MinDistanceMm > config.MaxDistanceMm {
+		return nil, nil, errors.New("min_distance_mm must not exceed max_distance_mm")
+	}
+	for _, box := range config.ExcludeBoxes {
+		if box.HalfSizeXMm < 0 || box.HalfSizeYMm < 0 || box.HalfSizeZMm < 0 {
+			return nil, nil, errors.New("exclude_boxes half sizes must not be negative")
+		}
+	}
+	if config.ROI != nil {
+		if config.ROI.MinXPx > config.ROI.MaxXPx || config.ROI.MinYPx > config.ROI.MaxYPx {
+			return nil, nil, errors.New("roi min bounds must not exceed max bounds")
+		}
+	}
+	if config.RequirePointCloud == nil {
+		requirePointCloud := true
+		config.RequirePointCloud = &requirePointCloud
+	}
+	if config.MaxConcurrentQueries == 0 {
+		config.MaxConcurrentQueries = config.NumQueries
+	}
+	if config.MaxConcurrentQueries < 1 || config.MaxConcurrentQueries > config.NumQueries {
+		return nil, nil, errors.New("max_concurrent_queries must be between 1 and num_queries")
+	}
+	if config.QueryTimeoutMs < 0 {
+		return nil, nil, errors.New("query_timeout_ms must not be negative")
+	}
+
 	return reqDeps, optDeps, nil
 }
 
 func registerObstacleDistanceDetector(
-    ctx context.Context,
-    name resource.Name,
-    conf *DistanceDetectorConfig,
-    deps resource.Dependencies,
+	ctx context.Context,
+	name resource.Name,
+	conf *DistanceDetectorConfig,
+	deps resource.Dependencies,
 ) (vision.Service, error) {
-    _, span := trace.StartSpan(ctx, "service::vision::registerObstacleDistanceDetector")
-    defer span.End()
-    
-    if conf == nil {
-        return nil, errors.New("config for obstacles_distance cannot be nil")
-    }
-
-    segmenter := func(ctx context.Context, src camera.Camera) ([]*vis.Object, error) {
-        // Your existing segmenter logic here
-        clouds := make([]pointcloud.PointCloud, 0, conf.NumQueries)
-        
-        for i := 0; i < conf.NumQueries; i++ {
-            nxtPC, err := src.NextPointCloud(ctx)
-            if err != nil {
-                return nil, err
-            }
-            if nxtPC.Size() == 0 {
-                continue
-            }
-            clouds = append(clouds, nxtPC)
-        }
-        
-        if len(clouds) == 0 {
-            return nil, errors.New("none of the input point clouds contained any points")
-        }
-
-        median, err := medianFromPointClouds(ctx, clouds)
-        if err != nil {
-            return nil, err
-        }
-
-        vector := pointcloud.NewVector(median.X, median.Y, median.Z)
-        pt := spatialmath.NewPoint(vector, "obstacle")
-
-        pcToReturn := pointcloud.NewBasicEmpty()
-        basicData := pointcloud.NewBasicData()
-        err = pcToReturn.Set(vector, basicData)
-        if err != nil {
-            return nil, err
-        }
-
-        toReturn := make([]*vis.Object, 1)
-        toReturn[0] = &vis.Object{PointCloud: pcToReturn, Geometry: pt}
-
-        return toReturn, nil
-    }
+	_, span := trace.StartSpan(ctx, "service::vision::registerObstacleDistanceDetector")
+	defer span.End()
+
+	if conf == nil {
+		return nil, errors.New("config for obstacles_distance cannot be nil")
+	}
+
 	var defaultCam camera.Camera
 	var err error
-    if conf.DefaultCamera != "" {
-        defaultCam, err = camera.FromDependencies(deps, conf.DefaultCamera)
-        if err != nil {
-            return nil, errors.Errorf("could not find camera %q", conf.DefaultCamera)
-        }
-    }
+	if conf.DefaultCamera != "" {
+		defaultCam, err = camera.FromDependencies(deps, conf.DefaultCamera)
+		if err != nil {
+			return nil, errors.Errorf("could not find camera %q", conf.DefaultCamera)
+		}
+		if _, err := checkCameraCapability(ctx, defaultCam, *conf.RequirePointCloud, conf.ROI != nil); err != nil {
+			return nil, err
+		}
+	}
+
+	var movementSensor movementsensor.MovementSensor
+	if conf.MovementSensor != "" {
+		movementSensor, err = movementsensor.FromDependencies(deps, conf.MovementSensor)
+		if err != nil {
+			return nil, errors.Errorf("could not find movement sensor %q", conf.MovementSensor)
+		}
+	}
+
+	myObsDist := &obstacleDistanceService{
+		name:           name,
+		logger:         logging.NewLogger("obstacles-distance"),
+		segmenter:      buildObjects,
+		conf:           conf,
+		defaultCamera:  defaultCam,
+		movementSensor: movementSensor,
+		deps:           deps,
+	}
+
+	return myObsDist, nil
+}
+
+// buildObjects turns a batch of already-acquired point clouds into vision objects,
+// according to conf.Mode. It is a pure function of clouds and conf: all camera
+// acquisition (serial, parallel, or streaming) happens before this is called. intrinsics
+// is the acquiring camera's intrinsics, used to project points into image space when
+// conf.ROI is set; it may be nil when conf.ROI is unset.
+func buildObjects(
+	ctx context.Context, clouds []pointcloud.PointCloud, conf *DistanceDetectorConfig, intrinsics *transform.PinholeCameraIntrinsics,
+) ([]*vis.Object, pointcloud.PointCloud, int, error) {
+	rawMerged, err := mergeCloudsRaw(clouds)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	if conf.Mode == ModeRadiusClustering {
+		toReturn, filteredOut, err := clusterPointClouds(clouds, conf, intrinsics)
+		return toReturn, rawMerged, filteredOut, err
+	}
+
+	median, filteredOut, err := medianFromPointClouds(ctx, clouds, conf, intrinsics)
+	if err != nil {
+		return nil, rawMerged, filteredOut, err
+	}
+
+	vector := pointcloud.NewVector(median.X, median.Y, median.Z)
+	pt := spatialmath.NewPoint(vector, "obstacle")
+
+	pcToReturn := pointcloud.NewBasicEmpty()
+	basicData := pointcloud.NewBasicData()
+	if err := pcToReturn.Set(vector, basicData); err != nil {
+		return nil, rawMerged, filteredOut, err
+	}
+
+	toReturn := make([]*vis.Object, 1)
+	toReturn[0] = &vis.Object{PointCloud: pcToReturn, Geometry: pt}
+
+	return toReturn, rawMerged, filteredOut, nil
+}
+
+// cameraCapability records how a camera should be queried for point clouds, decided once
+// per acquisition rather than on every query, along with any intrinsics resolved for
+// image-space ROI filtering.
+type cameraCapability struct {
+	useProjector bool
+	intrinsics   *transform.PinholeCameraIntrinsics
+}
+
+// checkCameraCapability inspects cam's advertised properties and decides whether it can be
+// used directly (NextPointCloud) or, if requirePointCloud is false, via its projector as a
+// fallback for cameras that only expose images plus intrinsics (e.g. some depth cameras).
+// It returns an error for color-only cameras and for point-cloud-less cameras when
+// requirePointCloud is true. When needsIntrinsics is true (an ROI is configured), it also
+// resolves and returns cam's intrinsics, erroring if cam does not provide any.
+func checkCameraCapability(ctx context.Context, cam camera.Camera, requirePointCloud, needsIntrinsics bool) (cameraCapability, error) {
+	props, err := cam.Properties(ctx)
+	if err != nil {
+		return cameraCapability{}, errors.Wrap(err, "could not get camera properties")
+	}
+	if needsIntrinsics && props.IntrinsicParams == nil {
+		return cameraCapability{}, errors.New(
+			"camera does not provide intrinsics, required to project points into image space for the configured roi")
+	}
+	if props.SupportsPCD {
+		return cameraCapability{intrinsics: props.IntrinsicParams}, nil
+	}
+	if requirePointCloud {
+		return cameraCapability{}, errors.New(
+			"camera does not support point clouds; set require_point_cloud to false to fall back to image+intrinsics projection")
+	}
+	if props.ImageType == camera.ColorStream {
+		return cameraCapability{}, errors.New(
+			"camera is color-only and does not support point clouds, cannot be used for obstacle detection")
+	}
+	if props.IntrinsicParams == nil {
+		return cameraCapability{}, errors.New(
+			"camera supports neither point clouds nor depth image intrinsics, cannot be used for obstacle detection")
+	}
+	return cameraCapability{useProjector: true, intrinsics: props.IntrinsicParams}, nil
+}
+
+// acquirePointCloud gets the next point cloud from cam according to capability, using the
+// camera's own projector to synthesize one from its depth image when cam does not support
+// NextPointCloud directly.
+func acquirePointCloud(ctx context.Context, cam camera.Camera, capability cameraCapability) (pointcloud.PointCloud, error) {
+	if !capability.useProjector {
+		return cam.NextPointCloud(ctx)
+	}
+	return projectPointCloudFromImage(ctx, cam)
+}
+
+// projectPointCloudFromImage builds a point cloud from cam's depth image and intrinsics,
+// for cameras that only expose image streams rather than NextPointCloud.
+func projectPointCloudFromImage(ctx context.Context, cam camera.Camera) (pointcloud.PointCloud, error) {
+	props, err := cam.Properties(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if props.IntrinsicParams == nil {
+		return nil, errors.New("camera does not provide intrinsics for point cloud projection")
+	}
+
+	img, err := camera.DecodeImageFromCamera(ctx, utils.MimeTypeRawDepth, nil, cam)
+	if err != nil {
+		return nil, err
+	}
+	depthMap, ok := img.(*rimage.DepthMap)
+	if !ok {
+		return nil, errors.New("camera did not return a depth image for point cloud projection")
+	}
+
+	return props.IntrinsicParams.DepthMapToPointCloud(depthMap, nil)
+}
+
+// timestampedCloud pairs an acquired point cloud with the wall-clock time it was
+// acquired at, so later samples can be motion-compensated back to the first sample's frame.
+type timestampedCloud struct {
+	cloud pointcloud.PointCloud
+	at    time.Time
+}
+
+// plainClouds strips the timestamps back off, for the (default) uncompensated path.
+func plainClouds(clouds []timestampedCloud) []pointcloud.PointCloud {
+	out := make([]pointcloud.PointCloud, len(clouds))
+	for i, c := range clouds {
+		out[i] = c.cloud
+	}
+	return out
+}
+
+// warnMotionFallback logs, once per service lifetime, that motion compensation is falling
+// back to uncompensated clouds, and why.
+func (s *obstacleDistanceService) warnMotionFallback(err error) {
+	s.motionWarnOnce.Do(func() {
+		s.logger.Warnw("movement sensor motion compensation unavailable, falling back to uncompensated clouds", "error", err)
+	})
+}
+
+// compensateForMotion normalizes every acquired cloud back into the frame of the first
+// sample using s.movementSensor, if one is configured. If no movement sensor is configured,
+// it returns the uncompensated clouds without logging. If sampling the sensor fails, or it
+// doesn't report the properties we need, this logs once and falls back to the uncompensated
+// clouds rather than failing the query. This is used for the
+// Streaming path, where clouds already sit in the rolling window by the time a caller asks
+// for them: velocity necessarily gets sampled after acquisition, there is no "between
+// samples" opportunity to interleave with. acquireAndCompensateSerially interleaves sampling
+// with acquisition instead, for the non-streaming path.
+func (s *obstacleDistanceService) compensateForMotion(ctx context.Context, clouds []timestampedCloud) []pointcloud.PointCloud {
+	s.mu.RLock()
+	movementSensor := s.movementSensor
+	s.mu.RUnlock()
+
+	if movementSensor == nil || len(clouds) < 2 {
+		return plainClouds(clouds)
+	}
+
+	compensated, err := transformCloudsToFirstFrame(ctx, movementSensor, clouds)
+	if err != nil {
+		s.warnMotionFallback(err)
+		return plainClouds(clouds)
+	}
+	return compensated
+}
+
+// transformCloudsToFirstFrame samples sensor's linear and angular velocity once per
+// consecutive pair of clouds, integrates that into a corrective pose for the interval, and
+// composes corrective poses cumulatively so every cloud ends up expressed in the frame of
+// clouds[0]. clouds must be in chronological order. Because clouds is already fully
+// acquired by the time this runs, every sample happens in a tight loop after the fact rather
+// than truly "between" each pair of acquisitions; it approximates per-interval motion using
+// whatever velocity the sensor reports at read time, which is a reasonable approximation for
+// Streaming's rolling window but is not as accurate as sampling during acquisition.
+func transformCloudsToFirstFrame(
+	ctx context.Context, sensor movementsensor.MovementSensor, clouds []timestampedCloud,
+) ([]pointcloud.PointCloud, error) {
+	props, err := sensor.Properties(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if !props.LinearVelocitySupported && !props.AngularVelocitySupported {
+		return nil, errors.New("movement sensor does not support linear or angular velocity")
+	}
+
+	out := make([]pointcloud.PointCloud, len(clouds))
+	out[0] = clouds[0].cloud
+	cumulative := spatialmath.NewZeroPose()
+	for i := 1; i < len(clouds); i++ {
+		dt := clouds[i].at.Sub(clouds[i-1].at).Seconds()
+
+		var linear r3.Vector
+		if props.LinearVelocitySupported {
+			linear, err = sensor.LinearVelocity(ctx, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+		var angular spatialmath.AngularVelocity
+		if props.AngularVelocitySupported {
+			angular, err = sensor.AngularVelocity(ctx, nil)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		step := correctivePose(linear, angular, dt)
+		cumulative = spatialmath.Compose(cumulative, step)
+		transformed, err := transformCloud(clouds[i].cloud, cumulative)
+		if err != nil {
+			return nil, err
+		}
+		out[i] = transformed
+	}
+	return out, nil
+}
+
+// correctivePose builds the pose that undoes the motion implied by linear (m/sec, a hard
+// precondition: must already be expressed in the camera frame, not the movement sensor's
+// body/world frame -- no camera<->sensor frame transform is applied) and angular (deg/sec)
+// velocity over dt seconds, so a point observed at the end of that interval can be moved
+// back into the frame at its start. Linear velocity is converted to mm, matching the units
+// pointclouds use.
+func correctivePose(linear r3.Vector, angular spatialmath.AngularVelocity, dt float64) spatialmath.Pose {
+	const metersToMm = 1000.0
+	translation := r3.Vector{X: -linear.X * dt * metersToMm, Y: -linear.Y * dt * metersToMm, Z: -linear.Z * dt * metersToMm}
+	orientation := &spatialmath.EulerAngles{
+		Roll:  -angular.X * dt * math.Pi / 180,
+		Pitch: -angular.Y * dt * math.Pi / 180,
+		Yaw:   -angular.Z * dt * math.Pi / 180,
+	}
+	return spatialmath.NewPose(translation, orientation)
+}
+
+// transformCloud applies pose to every point in cloud, returning a new cloud; the original
+// is left untouched.
+func transformCloud(cloud pointcloud.PointCloud, pose spatialmath.Pose) (pointcloud.PointCloud, error) {
+	out := pointcloud.NewBasicEmpty()
+	var setErr error
+	cloud.Iterate(0, 0, func(p r3.Vector, d pointcloud.Data) bool {
+		transformed := spatialmath.Compose(pose, spatialmath.NewPoseFromPoint(p)).Point()
+		if err := out.Set(transformed, d); err != nil {
+			setErr = err
+			return false
+		}
+		return true
+	})
+	if setErr != nil {
+		return nil, setErr
+	}
+	return out, nil
+}
+
+// acquireCloudsConcurrently runs up to conf.NumQueries NextPointCloud (or projector)
+// calls, at most conf.MaxConcurrentQueries at a time, aborting the remaining calls on the
+// first error. Each call is individually bounded by conf.QueryTimeoutMs, if set.
+func acquireCloudsConcurrently(
+	ctx context.Context, cam camera.Camera, conf *DistanceDetectorConfig, capability cameraCapability,
+) ([]timestampedCloud, error) {
+	g, gctx := errgroup.WithContext(ctx)
+	sem := make(chan struct{}, conf.MaxConcurrentQueries)
+	results := make([]timestampedCloud, conf.NumQueries)
+
+	for i := 0; i < conf.NumQueries; i++ {
+		i := i
+		g.Go(func() error {
+			select {
+			case sem <- struct{}{}:
+			case <-gctx.Done():
+				return gctx.Err()
+			}
+			defer func() { <-sem }()
+
+			queryCtx := gctx
+			if conf.QueryTimeoutMs > 0 {
+				var cancel context.CancelFunc
+				queryCtx, cancel = context.WithTimeout(gctx, time.Duration(conf.QueryTimeoutMs)*time.Millisecond)
+				defer cancel()
+			}
+
+			pc, err := acquirePointCloud(queryCtx, cam, capability)
+			if err != nil {
+				return err
+			}
+			results[i] = timestampedCloud{cloud: pc, at: time.Now()}
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	clouds := make([]timestampedCloud, 0, len(results))
+	for _, tc := range results {
+		if tc.cloud != nil && tc.cloud.Size() > 0 {
+			clouds = append(clouds, tc)
+		}
+	}
+	return clouds, nil
+}
+
+// acquireAndCompensateSerially runs conf.NumQueries NextPointCloud (or projector) calls one
+// at a time, aborting the remaining calls on the first acquisition error. Used instead of
+// acquireCloudsConcurrently when a movement sensor is configured: concurrent acquisition
+// timestamps every cloud at roughly the same instant, which would make motion compensation a
+// no-op (dt ~= 0 between every pair), so serial, genuinely spaced-out timestamps are needed.
+//
+// Velocity is sampled from sensor immediately after each acquisition and before the next one
+// starts, interleaved with acquisition rather than batched into a second pass once every
+// cloud is in hand -- that sample is as close to "between" the pair of samples it corrects
+// for as a single-goroutine acquire-then-sample loop can get. If sensor sampling fails or it
+// doesn't support the properties needed, this logs once and falls back to the uncompensated
+// clouds (any still-successful acquisitions are kept) rather than failing the query.
+func (s *obstacleDistanceService) acquireAndCompensateSerially(
+	ctx context.Context, cam camera.Camera, conf *DistanceDetectorConfig, capability cameraCapability,
+	sensor movementsensor.MovementSensor,
+) ([]pointcloud.PointCloud, error) {
+	props, err := sensor.Properties(ctx, nil)
+	compensate := err == nil && (props.LinearVelocitySupported || props.AngularVelocitySupported)
+	if err != nil {
+		s.warnMotionFallback(err)
+	} else if !compensate {
+		s.warnMotionFallback(errors.New("movement sensor does not support linear or angular velocity"))
+	}
+
+	out := make([]pointcloud.PointCloud, 0, conf.NumQueries)
+	var prevAt time.Time
+	cumulative := spatialmath.NewZeroPose()
+
+	for i := 0; i < conf.NumQueries; i++ {
+		queryCtx := ctx
+		if conf.QueryTimeoutMs > 0 {
+			var cancel context.CancelFunc
+			queryCtx, cancel = context.WithTimeout(ctx, time.Duration(conf.QueryTimeoutMs)*time.Millisecond)
+			defer cancel()
+		}
+		pc, err := acquirePointCloud(queryCtx, cam, capability)
+		if err != nil {
+			return nil, err
+		}
+		if pc == nil || pc.Size() == 0 {
+			continue
+		}
+		at := time.Now()
+
+		if len(out) == 0 || !compensate {
+			out = append(out, pc)
+			prevAt = at
+			continue
+		}
+
+		dt := at.Sub(prevAt).Seconds()
+		var linear r3.Vector
+		if props.LinearVelocitySupported {
+			linear, err = sensor.LinearVelocity(ctx, nil)
+		}
+		var angular spatialmath.AngularVelocity
+		if err == nil && props.AngularVelocitySupported {
+			angular, err = sensor.AngularVelocity(ctx, nil)
+		}
+		if err != nil {
+			s.warnMotionFallback(err)
+			compensate = false
+			out = append(out, pc)
+			prevAt = at
+			continue
+		}
+
+		step := correctivePose(linear, angular, dt)
+		cumulative = spatialmath.Compose(cumulative, step)
+		transformed, err := transformCloud(pc, cumulative)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, transformed)
+		prevAt = at
+	}
+	return out, nil
+}
+
+// ringBuffer holds the most recent point clouds acquired by a background streamer, up to
+// a fixed capacity, overwriting the oldest entry once full. ready is closed after the first
+// successful push, so callers can block until the buffer has warmed up instead of observing
+// an empty snapshot.
+type ringBuffer struct {
+	mu        sync.Mutex
+	clouds    []timestampedCloud
+	next      int
+	filled    bool
+	ready     chan struct{}
+	readyOnce sync.Once
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{clouds: make([]timestampedCloud, size), ready: make(chan struct{})}
+}
+
+func (r *ringBuffer) push(tc timestampedCloud) {
+	r.mu.Lock()
+	r.clouds[r.next] = tc
+	r.next++
+	if r.next == len(r.clouds) {
+		r.next = 0
+		r.filled = true
+	}
+	r.mu.Unlock()
+	r.readyOnce.Do(func() { close(r.ready) })
+}
+
+// snapshot returns the buffered clouds oldest-first. Once the buffer has wrapped around,
+// the oldest entry is the one the next push will overwrite (at index next), so the
+// chronological order rotates r.clouds starting there rather than returning slot order.
+func (r *ringBuffer) snapshot() []timestampedCloud {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.filled {
+		out := make([]timestampedCloud, 0, r.next)
+		for _, tc := range r.clouds[:r.next] {
+			if tc.cloud != nil {
+				out = append(out, tc)
+			}
+		}
+		return out
+	}
+	out := make([]timestampedCloud, 0, len(r.clouds))
+	for i := 0; i < len(r.clouds); i++ {
+		tc := r.clouds[(r.next+i)%len(r.clouds)]
+		if tc.cloud != nil {
+			out = append(out, tc)
+		}
+	}
+	return out
+}
+
+// ensureStreaming lazily starts the background acquisition goroutine backing Streaming
+// mode, returning its ring buffer. Subsequent calls reuse the same goroutine and buffer
+// until Close tears it down.
+func (s *obstacleDistanceService) ensureStreaming(
+	cam camera.Camera, conf *DistanceDetectorConfig, capability cameraCapability,
+) *ringBuffer {
+	s.streamMu.Lock()
+	defer s.streamMu.Unlock()
+	if s.streamBuffer != nil {
+		return s.streamBuffer
+	}
+
+	buffer := newRingBuffer(conf.NumQueries)
+	streamCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	s.streamBuffer = buffer
+	s.streamCancel = cancel
+	s.streamDone = done
+
+	go func() {
+		defer close(done)
+		for streamCtx.Err() == nil {
+			queryCtx := streamCtx
+			var qcancel context.CancelFunc
+			if conf.QueryTimeoutMs > 0 {
+				queryCtx, qcancel = context.WithTimeout(streamCtx, time.Duration(conf.QueryTimeoutMs)*time.Millisecond)
+			}
+			start := time.Now()
+			pc, err := acquirePointCloud(queryCtx, cam, capability)
+			if qcancel != nil {
+				qcancel()
+			}
+			if err != nil {
+				s.stats.record(time.Since(start), true, 0)
+				select {
+				case <-streamCtx.Done():
+				case <-time.After(streamErrorBackoff(conf)):
+				}
+				continue
+			}
+			s.stats.record(time.Since(start), pc.Size() == 0, 0)
+			if pc.Size() > 0 {
+				buffer.push(timestampedCloud{cloud: pc, at: time.Now()})
+			}
+		}
+	}()
+
+	return buffer
+}
 
-    myObsDist := &obstacleDistanceService{
-        name:          name,
-        logger:        logging.NewLogger("obstacles-distance"),
-        segmenter:     segmenter,
-        defaultCamera: defaultCam,
-        deps:          deps,
-    }
+// minStreamErrorBackoff is the floor on how long the streaming goroutine waits after a
+// failed acquisition before retrying, so a persistently erroring camera cannot spin the
+// loop at full CPU and flood the stats window with failures.
+const minStreamErrorBackoff = 200 * time.Millisecond
+
+// streamErrorBackoff returns how long ensureStreaming's goroutine should wait after a
+// failed acquisition: QueryTimeoutMs if it is set and larger, otherwise minStreamErrorBackoff.
+func streamErrorBackoff(conf *DistanceDetectorConfig) time.Duration {
+	if ms := time.Duration(conf.QueryTimeoutMs) * time.Millisecond; ms > minStreamErrorBackoff {
+		return ms
+	}
+	return minStreamErrorBackoff
+}
+
+// stopStreaming cancels and waits for the background streaming goroutine, if one is
+// running. It is safe to call even if streaming was never started.
+func (s *obstacleDistanceService) stopStreaming() {
+	s.streamMu.Lock()
+	cancel := s.streamCancel
+	done := s.streamDone
+	s.streamMu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// restartStreaming tears down the background streaming goroutine and clears the stream
+// state, so the next GetObjectPointClouds call in Streaming mode lazily starts a fresh one
+// via ensureStreaming, picking up the latest conf (ring buffer size) and camera. It must be
+// called after any DoCommand mutation the running streamer captured at start time, such as
+// set_num_queries or set_default_camera, or those changes silently have no effect.
+func (s *obstacleDistanceService) restartStreaming() {
+	s.stopStreaming()
+	s.streamMu.Lock()
+	s.streamBuffer = nil
+	s.streamCancel = nil
+	s.streamDone = nil
+	s.streamMu.Unlock()
+}
 
-    return myObsDist, nil
+// mergeCloudsRaw combines the unfiltered acquired clouds into a single point cloud, used
+// for diagnostics (the snapshot DoCommand). Filtering is intentionally not applied here.
+func mergeCloudsRaw(clouds []pointcloud.PointCloud) (pointcloud.PointCloud, error) {
+	merged := pointcloud.NewBasicEmpty()
+	for _, cloud := range clouds {
+		var setErr error
+		cloud.Iterate(0, 0, func(pt r3.Vector, d pointcloud.Data) bool {
+			setErr = merged.Set(pointcloud.NewVector(pt.X, pt.Y, pt.Z), pointcloud.NewBasicData())
+			return setErr == nil
+		})
+		if setErr != nil {
+			return nil, setErr
+		}
+	}
+	return merged, nil
 }
 
-func medianFromPointClouds(ctx context.Context, clouds []pointcloud.PointCloud) (r3.Vector, error) {
+func medianFromPointClouds(
+	ctx context.Context, clouds []pointcloud.PointCloud, conf *DistanceDetectorConfig, intrinsics *transform.PinholeCameraIntrinsics,
+) (r3.Vector, int, error) {
 	var results [][]r3.Vector // a slice for each process, which will contain a slice of vectors
+	var filteredCounts []int  // filtered-out point count per process, indexed like results
 	err := utils.GroupWorkParallel(
 		ctx,
 		len(clouds),
 		func(numGroups int) {
 			results = make([][]r3.Vector, numGroups)
+			filteredCounts = make([]int, numGroups)
 		},
 		func(groupNum, groupSize, from, to int) (utils.MemberWorkFunc, utils.GroupWorkDoneFunc) {
 			closestPoints := make([]r3.Vector, 0, groupSize)
+			filteredOut := 0
 			return func(memberNum, workNum int) {
-					closestPoint := getClosestPoint(clouds[workNum])
-					closestPoints = append(closestPoints, closestPoint)
+					closestPoint, ok, filteredOutInCloud := filteredClosestPoint(clouds[workNum], conf, intrinsics)
+					filteredOut += filteredOutInCloud
+					if ok {
+						closestPoints = append(closestPoints, closestPoint)
+					}
 				}, func() {
 					results[groupNum] = closestPoints
+					filteredCounts[groupNum] = filteredOut
 				}
 		},
 	)
 	if err != nil {
-		return r3.Vector{}, err
+		return r3.Vector{}, 0, err
 	}
 	candidates := make([]r3.Vector, 0, len(clouds))
-	for _, r := range results {
+	totalFilteredOut := 0
+	for i, r := range results {
 		candidates = append(candidates, r...)
+		totalFilteredOut += filteredCounts[i]
 	}
 	if len(candidates) == 0 {
-		return r3.Vector{}, errors.New("point cloud list is empty, could not find median point")
+		return r3.Vector{}, totalFilteredOut, errors.New("no points remained after filtering, could not find median point")
 	}
-	return getMedianPoint(candidates), nil
+	return getMedianPoint(candidates), totalFilteredOut, nil
 }
 
-func getClosestPoint(cloud pointcloud.PointCloud) r3.Vector {
+// pointPassesFilters reports whether pt survives the configured distance gates, exclude
+// boxes, and ROI. It is shared by the nearest/median path and radius clustering so both
+// modes honor the same filtering configuration. intrinsics projects pt into image space for
+// the ROI check; it is only dereferenced when conf.ROI is set, and conf.ROI being set implies
+// a non-nil intrinsics (checkCameraCapability requires it at acquisition time).
+func pointPassesFilters(pt r3.Vector, conf *DistanceDetectorConfig, intrinsics *transform.PinholeCameraIntrinsics) bool {
+	dist := pt.Norm()
+	if conf.MinDistanceMm > 0 && dist < conf.MinDistanceMm {
+		return false
+	}
+	if conf.MaxDistanceMm > 0 && dist > conf.MaxDistanceMm {
+		return false
+	}
+	for _, box := range conf.ExcludeBoxes {
+		if math.Abs(pt.X-box.CenterXMm) <= box.HalfSizeXMm &&
+			math.Abs(pt.Y-box.CenterYMm) <= box.HalfSizeYMm &&
+			math.Abs(pt.Z-box.CenterZMm) <= box.HalfSizeZMm {
+			return false
+		}
+	}
+	if conf.ROI != nil {
+		px, py := intrinsics.PointToPixel(pt.X, pt.Y, pt.Z)
+		if px < conf.ROI.MinXPx || px > conf.ROI.MaxXPx || py < conf.ROI.MinYPx || py > conf.ROI.MaxYPx {
+			return false
+		}
+	}
+	return true
+}
+
+// filteredClosestPoint finds the closest point in cloud to the origin that passes
+// pointPassesFilters, applying the filter during the single iteration pass rather than
+// allocating a filtered copy of the cloud. ok is false if every point was filtered out.
+// filteredOut counts how many points in cloud failed the filter, for diagnostics.
+func filteredClosestPoint(
+	cloud pointcloud.PointCloud, conf *DistanceDetectorConfig, intrinsics *transform.PinholeCameraIntrinsics,
+) (pt r3.Vector, ok bool, filteredOut int) {
 	minDistance := math.MaxFloat64
 	minPoint := r3.Vector{}
-	cloud.Iterate(0, 0, func(pt r3.Vector, d pointcloud.Data) bool {
-		dist := pt.Norm2()
+	cloud.Iterate(0, 0, func(candidate r3.Vector, d pointcloud.Data) bool {
+		if !pointPassesFilters(candidate, conf, intrinsics) {
+			filteredOut++
+			return true
+		}
+		dist := candidate.Norm2()
 		if dist < minDistance {
 			minDistance = dist
-			minPoint = pt
+			minPoint = candidate
+			ok = true
 		}
 		return true
 	})
-	return minPoint
+	return minPoint, ok, filteredOut
 }
 
 // to calculate the median, will need to sort the vectors by distance from origin.
@@ -216,37 +987,228 @@ func getMedianPoint(pts []r3.Vector) r3.Vector {
 	return pts[index]
 }
 
-func (s *obstacleDistanceService) GetObjectPointClouds(ctx context.Context, cameraName string, extra map[string]interface{}) ([]*vis.Object, error) {
-    var cam camera.Camera
-    var err error
+// clusterCell identifies a cell in the spatial hash grid used by radius clustering.
+type clusterCell struct {
+	x, y, z int
+}
 
-    if cameraName != "" {
-        cam, err = camera.FromDependencies(s.deps, cameraName)
-        if err != nil {
-            return nil, err
-        }
-    } else if s.defaultCamera != nil {
-        cam = s.defaultCamera
-    } else {
-        return nil, errors.New("no camera specified")
-    }
+func cellForPoint(pt r3.Vector, radius float64) clusterCell {
+	return clusterCell{
+		x: int(math.Floor(pt.X / radius)),
+		y: int(math.Floor(pt.Y / radius)),
+		z: int(math.Floor(pt.Z / radius)),
+	}
+}
 
-    return s.segmenter(ctx, cam)
+// unionFind is a minimal disjoint-set structure used to merge points into clusters.
+type unionFind struct {
+	parent []int
 }
 
-func (s *obstacleDistanceService) CaptureAllFromCamera(ctx context.Context, cameraName string, captureOptions viscapture.CaptureOptions, extra map[string]interface{}) (viscapture.VisCapture, error) {
-    var cam camera.Camera
-    var err error
+func newUnionFind(n int) *unionFind {
+	parent := make([]int, n)
+	for i := range parent {
+		parent[i] = i
+	}
+	return &unionFind{parent: parent}
+}
+
+func (u *unionFind) find(i int) int {
+	for u.parent[i] != i {
+		u.parent[i] = u.parent[u.parent[i]]
+		i = u.parent[i]
+	}
+	return i
+}
+
+func (u *unionFind) union(i, j int) {
+	ri, rj := u.find(i), u.find(j)
+	if ri != rj {
+		u.parent[ri] = rj
+	}
+}
+
+// clusterPointClouds merges the accumulated clouds into one working set, discards points
+// outside the configured distance gates, and groups the remainder into clusters using a
+// spatial hash grid keyed on ClusteringRadiusMm. One *vis.Object is returned per cluster
+// that meets MinClusterSize, geometried as the centroid of its member points.
+func clusterPointClouds(
+	clouds []pointcloud.PointCloud, conf *DistanceDetectorConfig, intrinsics *transform.PinholeCameraIntrinsics,
+) ([]*vis.Object, int, error) {
+	radius := conf.ClusteringRadiusMm
+
+	var allPoints []r3.Vector
+	filteredOut := 0
+	for _, cloud := range clouds {
+		cloud.Iterate(0, 0, func(pt r3.Vector, d pointcloud.Data) bool {
+			if pointPassesFilters(pt, conf, intrinsics) {
+				allPoints = append(allPoints, pt)
+			} else {
+				filteredOut++
+			}
+			return true
+		})
+	}
+	if len(allPoints) == 0 {
+		return nil, filteredOut, errors.New("no points remained after filtering, could not cluster obstacles")
+	}
+
+	grid := make(map[clusterCell][]int, len(allPoints))
+	for i, pt := range allPoints {
+		cell := cellForPoint(pt, radius)
+		grid[cell] = append(grid[cell], i)
+	}
+
+	uf := newUnionFind(len(allPoints))
+	for i, pt := range allPoints {
+		cell := cellForPoint(pt, radius)
+		for dx := -1; dx <= 1; dx++ {
+			for dy := -1; dy <= 1; dy++ {
+				for dz := -1; dz <= 1; dz++ {
+					neighborCell := clusterCell{cell.x + dx, cell.y + dy, cell.z + dz}
+					for _, j := range grid[neighborCell] {
+						if j <= i {
+							continue
+						}
+						if pt.Sub(allPoints[j]).Norm() <= radius {
+							uf.union(i, j)
+						}
+					}
+				}
+			}
+		}
+	}
 
-    if cameraName != "" {
-		cam, err = camera.FromDependencies(s.deps, cameraName)
+	memberIdxs := make(map[int][]int)
+	for i := range allPoints {
+		root := uf.find(i)
+		memberIdxs[root] = append(memberIdxs[root], i)
+	}
+
+	var toReturn []*vis.Object
+	for _, idxs := range memberIdxs {
+		if len(idxs) < conf.MinClusterSize {
+			continue
+		}
+
+		pcToReturn := pointcloud.NewBasicEmpty()
+		var centroid r3.Vector
+		for _, i := range idxs {
+			centroid = centroid.Add(allPoints[i])
+			if err := pcToReturn.Set(pointcloud.NewVector(allPoints[i].X, allPoints[i].Y, allPoints[i].Z), pointcloud.NewBasicData()); err != nil {
+				return nil, filteredOut, err
+			}
+		}
+		centroid = centroid.Mul(1.0 / float64(len(idxs)))
+
+		geometry := spatialmath.NewPoint(pointcloud.NewVector(centroid.X, centroid.Y, centroid.Z), "obstacle")
+		toReturn = append(toReturn, &vis.Object{PointCloud: pcToReturn, Geometry: geometry})
+	}
+
+	if len(toReturn) == 0 {
+		return nil, filteredOut, errors.New("no clusters met min_cluster_size, could not find any obstacles")
+	}
+
+	return toReturn, filteredOut, nil
+}
+
+// config returns a point-in-time copy of the service's mutable config, safe to read
+// without holding a lock for the duration of a (potentially slow) acquisition.
+func (s *obstacleDistanceService) config() *DistanceDetectorConfig {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	confCopy := *s.conf
+	return &confCopy
+}
+
+func (s *obstacleDistanceService) camera(cameraName string) (camera.Camera, error) {
+	if cameraName != "" {
+		return camera.FromDependencies(s.deps, cameraName)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.defaultCamera == nil {
+		return nil, errors.New("no camera specified")
+	}
+	return s.defaultCamera, nil
+}
+
+func (s *obstacleDistanceService) GetObjectPointClouds(ctx context.Context, cameraName string, extra map[string]interface{}) ([]*vis.Object, error) {
+	cam, err := s.camera(cameraName)
+	if err != nil {
+		return nil, err
+	}
+	conf := s.config()
+
+	capability, err := checkCameraCapability(ctx, cam, *conf.RequirePointCloud, conf.ROI != nil)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+
+	var clouds []pointcloud.PointCloud
+	switch {
+	case conf.Streaming:
+		buffer := s.ensureStreaming(cam, conf, capability)
+		// The first call (or any call before the streamer has pushed at least once) would
+		// otherwise see an empty buffer and fail; block until it warms up instead, bounded
+		// by the caller's ctx, so Streaming mode returns the current buffer contents rather
+		// than racing the background goroutine's first acquisition.
+		select {
+		case <-buffer.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		timestamped := buffer.snapshot()
+		if len(timestamped) == 0 {
+			s.stats.record(time.Since(start), true, 0)
+			return nil, errors.New("none of the input point clouds contained any points")
+		}
+		clouds = s.compensateForMotion(ctx, timestamped)
+
+	default:
+		s.mu.RLock()
+		movementSensor := s.movementSensor
+		s.mu.RUnlock()
+		if movementSensor != nil {
+			// Serial acquisition, with velocity sampled between acquisitions as they
+			// happen: see acquireAndCompensateSerially. Concurrent acquisition would
+			// timestamp every cloud at roughly the same instant and make motion
+			// compensation a no-op.
+			clouds, err = s.acquireAndCompensateSerially(ctx, cam, conf, capability, movementSensor)
+		} else {
+			var timestamped []timestampedCloud
+			timestamped, err = acquireCloudsConcurrently(ctx, cam, conf, capability)
+			clouds = plainClouds(timestamped)
+		}
 		if err != nil {
-			return viscapture.VisCapture{}, err
+			s.stats.record(time.Since(start), true, 0)
+			return nil, err
+		}
+		if len(clouds) == 0 {
+			s.stats.record(time.Since(start), true, 0)
+			return nil, errors.New("none of the input point clouds contained any points")
 		}
-	} else if s.defaultCamera != nil {
-		cam = s.defaultCamera
-	} else {
-		return viscapture.VisCapture{}, errors.New("no camera specified")
+	}
+
+	toReturn, rawMerged, filteredOut, err := s.segmenter(ctx, clouds, conf, capability.intrinsics)
+	s.stats.record(time.Since(start), err != nil, filteredOut)
+	if rawMerged != nil {
+		s.mu.Lock()
+		s.lastRawCloud = rawMerged
+		s.mu.Unlock()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return toReturn, nil
+}
+
+func (s *obstacleDistanceService) CaptureAllFromCamera(ctx context.Context, cameraName string, captureOptions viscapture.CaptureOptions, extra map[string]interface{}) (viscapture.VisCapture, error) {
+	cam, err := s.camera(cameraName)
+	if err != nil {
+		return viscapture.VisCapture{}, err
 	}
 
 	result := viscapture.VisCapture{}
@@ -278,37 +1240,232 @@ func (s *obstacleDistanceService) NewClientFromConn(ctx context.Context, conn rp
 }
 
 func (s *obstacleDistanceService) Detections(ctx context.Context, img image.Image, extra map[string]interface{}) ([]objectdetection.Detection, error) {
-    return nil, errUnimplemented
+	return nil, errUnimplemented
 }
 
 func (s *obstacleDistanceService) DetectionsFromCamera(ctx context.Context, cameraName string, extra map[string]interface{}) ([]objectdetection.Detection, error) {
-    return nil, errUnimplemented
+	return nil, errUnimplemented
 }
 
 func (s *obstacleDistanceService) Classifications(ctx context.Context, img image.Image, count int, extra map[string]interface{}) (classification.Classifications, error) {
-    return nil, errUnimplemented
+	return nil, errUnimplemented
 }
 
 func (s *obstacleDistanceService) ClassificationsFromCamera(ctx context.Context, cameraName string, count int, extra map[string]interface{}) (classification.Classifications, error) {
-    return nil, errUnimplemented
+	return nil, errUnimplemented
 }
 
 func (s *obstacleDistanceService) GetProperties(ctx context.Context, extra map[string]interface{}) (*vision.Properties, error) {
-    return &vision.Properties{
-        ClassificationSupported: false,
-        DetectionSupported:      false,
-        ObjectPCDsSupported:     true,
-    }, nil
+	return &vision.Properties{
+		ClassificationSupported: false,
+		DetectionSupported:      false,
+		ObjectPCDsSupported:     true,
+	}, nil
 }
 
+// DoCommand implements a small runtime tuning and diagnostics protocol, keyed by the
+// "cmd" field:
+//   - {"cmd":"set_num_queries","value":N}
+//   - {"cmd":"set_default_camera","value":"cam1"}
+//   - {"cmd":"set_mode","value":"nearest|median|clustering"}
+//   - {"cmd":"set_filter", fields matching DistanceDetectorConfig's filter fields}
+//   - {"cmd":"get_stats"}
+//   - {"cmd":"snapshot"}
+//
+// All mutations are validated the same way as Validate and applied under s.mu so readers
+// in GetObjectPointClouds never observe a half-updated config. set_num_queries and
+// set_default_camera additionally restart any running Streaming-mode background streamer,
+// which otherwise keeps using the ring buffer size and camera it started with.
 func (s *obstacleDistanceService) DoCommand(ctx context.Context, cmd map[string]interface{}) (map[string]interface{}, error) {
-    return nil, errUnimplemented
+	name, ok := cmd["cmd"].(string)
+	if !ok {
+		return nil, errors.New("cmd must specify a \"cmd\" string field")
+	}
+
+	switch name {
+	case "set_num_queries":
+		value, ok := cmd["value"].(float64)
+		if !ok {
+			return nil, errors.New("set_num_queries requires a numeric \"value\"")
+		}
+		if err := s.updateConfig(func(c *DistanceDetectorConfig) {
+			c.NumQueries = int(value)
+			// Validate rejects MaxConcurrentQueries > NumQueries, and Validate auto-fills
+			// MaxConcurrentQueries to the old NumQueries on first load, so lowering
+			// NumQueries below that would otherwise always fail re-validation below.
+			if c.MaxConcurrentQueries > c.NumQueries {
+				c.MaxConcurrentQueries = c.NumQueries
+			}
+		}); err != nil {
+			return nil, err
+		}
+		// The running streamer, if any, captured the old NumQueries as its ring buffer
+		// size; restart it so Streaming mode picks up the new size.
+		s.restartStreaming()
+		return nil, nil
+
+	case "set_default_camera":
+		value, ok := cmd["value"].(string)
+		if !ok {
+			return nil, errors.New("set_default_camera requires a string \"value\"")
+		}
+		cam, err := camera.FromDependencies(s.deps, value)
+		if err != nil {
+			return nil, errors.Errorf("could not find camera %q", value)
+		}
+		conf := s.config()
+		if _, err := checkCameraCapability(ctx, cam, *conf.RequirePointCloud, conf.ROI != nil); err != nil {
+			return nil, err
+		}
+		s.mu.Lock()
+		s.conf.DefaultCamera = value
+		s.defaultCamera = cam
+		s.mu.Unlock()
+		// The running streamer, if any, captured the old camera at start time; restart
+		// it so Streaming mode queries the new camera.
+		s.restartStreaming()
+		return nil, nil
+
+	case "set_mode":
+		value, ok := cmd["value"].(string)
+		if !ok {
+			return nil, errors.New("set_mode requires a string \"value\"")
+		}
+		mode, err := normalizeMode(value)
+		if err != nil {
+			return nil, err
+		}
+		return nil, s.updateConfig(func(c *DistanceDetectorConfig) { c.Mode = mode })
+
+	case "set_filter":
+		return nil, s.updateConfig(func(c *DistanceDetectorConfig) { applyFilterCommand(c, cmd) })
+
+	case "get_stats":
+		return s.stats.snapshot(), nil
+
+	case "snapshot":
+		return s.snapshotPCD()
+
+	default:
+		return nil, errors.Errorf("unrecognized cmd %q", name)
+	}
+}
+
+// normalizeMode maps the "nearest"/"median"/"clustering" vocabulary used by DoCommand onto
+// the Mode values DistanceDetectorConfig understands; "nearest" and "median" both refer to
+// the same single closest-point-median algorithm.
+func normalizeMode(value string) (string, error) {
+	switch value {
+	case "nearest", "median":
+		return ModeNearest, nil
+	case "clustering", ModeRadiusClustering:
+		return ModeRadiusClustering, nil
+	default:
+		return "", errors.Errorf("invalid mode %q, must be one of nearest, median, clustering", value)
+	}
+}
+
+// applyFilterCommand copies any filter fields present in cmd onto c. Unrecognized keys are
+// ignored so set_filter can be called with a partial update. "roi" set to JSON null clears a
+// previously-set ROI back to nil; "exclude_boxes" set to an empty list clears the exclude
+// boxes, and otherwise replaces the list wholesale (it is not merged with the existing one).
+func applyFilterCommand(c *DistanceDetectorConfig, cmd map[string]interface{}) {
+	if v, ok := cmd["min_distance_mm"].(float64); ok {
+		c.MinDistanceMm = v
+	}
+	if v, ok := cmd["max_distance_mm"].(float64); ok {
+		c.MaxDistanceMm = v
+	}
+	if raw, ok := cmd["roi"]; ok {
+		if raw == nil {
+			c.ROI = nil
+		} else if v, ok := raw.(map[string]interface{}); ok {
+			roi := &ROI{}
+			if x, ok := v["min_x_px"].(float64); ok {
+				roi.MinXPx = x
+			}
+			if y, ok := v["min_y_px"].(float64); ok {
+				roi.MinYPx = y
+			}
+			if x, ok := v["max_x_px"].(float64); ok {
+				roi.MaxXPx = x
+			}
+			if y, ok := v["max_y_px"].(float64); ok {
+				roi.MaxYPx = y
+			}
+			c.ROI = roi
+		}
+	}
+	if raw, ok := cmd["exclude_boxes"].([]interface{}); ok {
+		boxes := make([]ExcludeBox, 0, len(raw))
+		for _, entry := range raw {
+			m, ok := entry.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			var box ExcludeBox
+			if x, ok := m["center_x_mm"].(float64); ok {
+				box.CenterXMm = x
+			}
+			if y, ok := m["center_y_mm"].(float64); ok {
+				box.CenterYMm = y
+			}
+			if z, ok := m["center_z_mm"].(float64); ok {
+				box.CenterZMm = z
+			}
+			if x, ok := m["half_size_x_mm"].(float64); ok {
+				box.HalfSizeXMm = x
+			}
+			if y, ok := m["half_size_y_mm"].(float64); ok {
+				box.HalfSizeYMm = y
+			}
+			if z, ok := m["half_size_z_mm"].(float64); ok {
+				box.HalfSizeZMm = z
+			}
+			boxes = append(boxes, box)
+		}
+		c.ExcludeBoxes = boxes
+	}
+}
+
+// updateConfig applies mutate to a copy of the current config, re-validates it, and only
+// then swaps it in, so a bad set_* command leaves the running config untouched.
+func (s *obstacleDistanceService) updateConfig(mutate func(*DistanceDetectorConfig)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	candidate := *s.conf
+	mutate(&candidate)
+	if _, _, err := candidate.Validate(""); err != nil {
+		return err
+	}
+	s.conf = &candidate
+	return nil
+}
+
+// snapshotPCD returns the last raw merged point cloud (before any filtering) encoded as
+// base64 PCD, for offline debugging of lag/bugginess without redeploying.
+func (s *obstacleDistanceService) snapshotPCD() (map[string]interface{}, error) {
+	s.mu.RLock()
+	cloud := s.lastRawCloud
+	s.mu.RUnlock()
+	if cloud == nil {
+		return nil, errors.New("no point cloud has been captured yet")
+	}
+
+	var buf bytes.Buffer
+	if err := pointcloud.ToPCD(cloud, &buf, pointcloud.PCDBinary); err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"pcd_base64": base64.StdEncoding.EncodeToString(buf.Bytes()),
+	}, nil
 }
 
 func (s *obstacleDistanceService) Name() resource.Name {
-    return s.name
+	return s.name
 }
 
 func (s *obstacleDistanceService) Close(ctx context.Context) error {
-    return nil
+	s.stopStreaming()
+	return nil
 }
@@ -0,0 +1,43 @@
+package obstaclesdistance
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/geo/r3"
+	"go.viam.com/rdk/pointcloud"
+	"go.viam.com/rdk/spatialmath"
+)
+
+// TestTransformCloudRotation guards against transformCloud applying only a pose's
+// translation and silently dropping its rotation (the Compose operand order determines
+// which of the two poses being composed gets to rotate the other's point).
+func TestTransformCloudRotation(t *testing.T) {
+	cloud := pointcloud.NewBasicEmpty()
+	pt := r3.Vector{X: 100, Y: 0, Z: 0}
+	if err := cloud.Set(pt, pointcloud.NewBasicData()); err != nil {
+		t.Fatalf("could not set up test point cloud: %v", err)
+	}
+
+	pose := spatialmath.NewPose(r3.Vector{}, &spatialmath.EulerAngles{Yaw: math.Pi / 2})
+
+	transformed, err := transformCloud(cloud, pose)
+	if err != nil {
+		t.Fatalf("transformCloud returned an error: %v", err)
+	}
+
+	var got r3.Vector
+	found := false
+	transformed.Iterate(0, 0, func(p r3.Vector, d pointcloud.Data) bool {
+		got = p
+		found = true
+		return true
+	})
+	if !found {
+		t.Fatal("transformed cloud did not contain the point")
+	}
+
+	if math.Abs(got.X-pt.X) < 1e-6 && math.Abs(got.Y-pt.Y) < 1e-6 {
+		t.Fatalf("expected a 90 degree yaw to move the off-axis point, but it stayed at %v", got)
+	}
+}